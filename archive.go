@@ -0,0 +1,481 @@
+package bigcompressor
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// tocMagic is the 8-byte trailer written at the very end of a combined
+// archive built with BuildIndex, so OpenArchive can tell an indexed archive
+// apart from an older combined archive that has no footer at all.
+const tocMagic = "BIGCMPv1"
+
+// tocChunk records where one compressed chunk lives inside the combined
+// outer file.
+type tocChunk struct {
+	ChunkNumber int   `json:"chunkNumber"`
+	Offset      int64 `json:"offset"`
+	Length      int64 `json:"length"`
+}
+
+// tocEntry records where one tar member lives: which chunk it was written
+// into, and its byte offset within that chunk's decompressed tar stream.
+type tocEntry struct {
+	Name        string `json:"name"`
+	ChunkNumber int    `json:"chunkNumber"`
+	TarOffset   int64  `json:"tarOffset"`
+	Size        int64  `json:"size"`
+	Mode        int64  `json:"mode"`
+}
+
+type tableOfContents struct {
+	Chunks  []tocChunk `json:"chunks"`
+	Entries []tocEntry `json:"entries"`
+}
+
+// writeFooter appends bc.toc to bc.compressFile as a JSON blob, followed by
+// an 8-byte big-endian length and the tocMagic trailer, so OpenArchive can
+// find it by reading backwards from EOF.
+func (bc *BigCompressor) writeFooter() error {
+	return writeTOCFooter(bc.compressFile, bc.toc)
+}
+
+// writeTOCFooter is writeFooter generalized to any io.Writer, so
+// CompressStream can append the same footer to a destination that isn't
+// necessarily bc.compressFile.
+func writeTOCFooter(w io.Writer, toc *tableOfContents) error {
+	data, err := json.Marshal(toc)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	var lenBuf [8]byte
+	binary.BigEndian.PutUint64(lenBuf[:], uint64(len(data)))
+	if _, err := w.Write(lenBuf[:]); err != nil {
+		return err
+	}
+	_, err = w.Write([]byte(tocMagic))
+	return err
+}
+
+// readFooterTOC reads the table of contents from the end of path, returning
+// (nil, nil) if path has no recognizable footer.
+func readFooterTOC(path string) (*tableOfContents, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if size < int64(len(tocMagic))+8 {
+		return nil, nil
+	}
+
+	magicBuf := make([]byte, len(tocMagic))
+	if _, err := f.ReadAt(magicBuf, size-int64(len(tocMagic))); err != nil {
+		return nil, err
+	}
+	if string(magicBuf) != tocMagic {
+		return nil, nil
+	}
+
+	lenBuf := make([]byte, 8)
+	if _, err := f.ReadAt(lenBuf, size-int64(len(tocMagic))-8); err != nil {
+		return nil, err
+	}
+	tocLen := int64(binary.BigEndian.Uint64(lenBuf))
+
+	jsonStart := size - int64(len(tocMagic)) - 8 - tocLen
+	if jsonStart < 0 {
+		return nil, nil
+	}
+	jsonBuf := make([]byte, tocLen)
+	if _, err := f.ReadAt(jsonBuf, jsonStart); err != nil {
+		return nil, err
+	}
+
+	toc := &tableOfContents{}
+	if err := json.Unmarshal(jsonBuf, toc); err != nil {
+		return nil, nil
+	}
+	return toc, nil
+}
+
+// countingReader mirrors countingWriter for the decode side, used by
+// scanTOC to locate tar headers while decoding a legacy chunk.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// tarOffsetTracker computes the offset each tar header started at, matching
+// what compressChunkNoAlloc records as a tocEntry's TarOffset: the position
+// right before the header (and, for a content-defined-chunking segment, the
+// PAX extension block carrying its BIGC.offset record) begins. tar.Reader
+// only skips a finished entry's padding at the very start of its *next*
+// Next() call, so a countingReader's byte count read right after Next()
+// returns is one entry stale for this purpose; tracking the previous entry's
+// content start and size lets next compute the correct offset without
+// needing to know how large the header block itself was.
+type tarOffsetTracker struct {
+	have         bool
+	contentStart int64
+	size         int64
+}
+
+// next returns the offset of the header tr.Next() just returned, given the
+// countingReader's byte count taken immediately after that call and the
+// header's content size.
+func (t *tarOffsetTracker) next(contentStart, size int64) int64 {
+	var offset int64
+	if t.have {
+		offset = t.contentStart + tarPadded(t.size)
+	}
+	t.contentStart, t.size, t.have = contentStart, size, true
+	return offset
+}
+
+// tarPadded rounds size up to the next multiple of the tar block size.
+func tarPadded(size int64) int64 {
+	const blockSize = 512
+	return (size + blockSize - 1) / blockSize * blockSize
+}
+
+// scanTOC rebuilds a table of contents in memory by linearly scanning an
+// older combined archive that has no footer, the same way Decompress does.
+// It lets OpenArchive serve List/Extract/Open against legacy archives too,
+// at the cost of a full scan on open instead of a footer read.
+func scanTOC(path string) (*tableOfContents, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	sep := chunkseparator.Bytes()
+	toc := &tableOfContents{}
+	var offset int64
+	chunkNumber := 0
+	for offset < int64(len(data)) {
+		rest := data[offset:]
+		idx := bytes.Index(rest, sep)
+		var chunkBytes []byte
+		if idx < 0 {
+			chunkBytes = rest
+		} else {
+			chunkBytes = rest[:idx]
+		}
+		if len(bytes.TrimSpace(chunkBytes)) > 10 {
+			entries, err := readChunkEntries(bytes.NewReader(chunkBytes), chunkNumber)
+			if err != nil {
+				return nil, err
+			}
+			toc.Chunks = append(toc.Chunks, tocChunk{
+				ChunkNumber: chunkNumber,
+				Offset:      offset,
+				Length:      int64(len(chunkBytes)),
+			})
+			toc.Entries = append(toc.Entries, entries...)
+			chunkNumber++
+		}
+		if idx < 0 {
+			break
+		}
+		offset += int64(idx) + int64(len(sep))
+	}
+	return toc, nil
+}
+
+// readChunkEntries decodes one chunk's tar stream to list its members,
+// recording each member's offset within the decompressed stream. The chunk's
+// codec is sniffed from its own leading bytes, the same way Decompress does,
+// so a combined archive may mix codecs across chunks.
+func readChunkEntries(r io.Reader, chunkNumber int) ([]tocEntry, error) {
+	br := bufio.NewReader(r)
+	peek, _ := br.Peek(maxCodecMagicLen)
+	codec := sniffCodec(peek)
+	if codec == nil {
+		codec = ZstdCodec{}
+	}
+	zr, err := codec.NewReader(br)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	cr := &countingReader{r: zr}
+	tr := tar.NewReader(cr)
+	var entries []tocEntry
+	var tracker tarOffsetTracker
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		tarOffset := tracker.next(cr.n, header.Size)
+		if header.Typeflag == tar.TypeReg {
+			entries = append(entries, tocEntry{
+				Name:        header.Name,
+				ChunkNumber: chunkNumber,
+				TarOffset:   tarOffset,
+				Size:        header.Size,
+				Mode:        header.Mode,
+			})
+		}
+	}
+	return entries, nil
+}
+
+// Entry describes one file stored in an indexed archive. With content-defined
+// chunking a file's content may be split across several chunks; Entry still
+// reports it as a single member with its full Size, and Open/Extract stitch
+// the pieces back together transparently.
+type Entry struct {
+	Name string
+	Size int64
+	Mode int64
+}
+
+// Archive gives random access to a combined archive built with
+// BigCompressor.CombineChunk, without scanning the whole file for every
+// lookup. Obtain one with BigCompressor.OpenArchive.
+type Archive struct {
+	path     string
+	chunks   []tocChunk
+	entries  []Entry
+	segments map[string][]tocEntry
+}
+
+// OpenArchive opens the combined archive at path for random access. If the
+// archive was built with BuildIndex, its footer is read directly; otherwise
+// the file is scanned once to build the same index in memory, so archives
+// produced before BuildIndex existed still work.
+func (bc *BigCompressor) OpenArchive(path string) (*Archive, error) {
+	toc, err := readFooterTOC(path)
+	if err != nil {
+		return nil, err
+	}
+	if toc == nil {
+		toc, err = scanTOC(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	a := &Archive{
+		path:     path,
+		chunks:   toc.Chunks,
+		segments: make(map[string][]tocEntry),
+	}
+	var order []string
+	for _, e := range toc.Entries {
+		if _, seen := a.segments[e.Name]; !seen {
+			order = append(order, e.Name)
+		}
+		a.segments[e.Name] = append(a.segments[e.Name], e)
+	}
+	a.entries = make([]Entry, len(order))
+	for i, name := range order {
+		segs := a.segments[name]
+		var size int64
+		for _, seg := range segs {
+			size += seg.Size
+		}
+		a.entries[i] = Entry{Name: name, Size: size, Mode: segs[0].Mode}
+	}
+	return a, nil
+}
+
+// List returns every file known to the archive's index.
+func (a *Archive) List() []Entry {
+	return a.entries
+}
+
+// chunkSegmentReader streams one tar member segment and releases the
+// chunk's decoder and file handle on Close.
+type chunkSegmentReader struct {
+	tr *tar.Reader
+	zr io.ReadCloser
+	f  *os.File
+}
+
+func (r *chunkSegmentReader) Read(p []byte) (int, error) {
+	return r.tr.Read(p)
+}
+
+func (r *chunkSegmentReader) Close() error {
+	zerr := r.zr.Close()
+	if ferr := r.f.Close(); ferr != nil {
+		return ferr
+	}
+	return zerr
+}
+
+// openSegment seeks directly to seg's chunk, decodes just that chunk with
+// its own codec (sniffed from its leading bytes, as Decompress does) and
+// returns a reader positioned at seg's own tar entry, matched by both name
+// and tar offset since content-defined chunking can put more than one
+// segment of the same file in a single chunk.
+func (a *Archive) openSegment(seg tocEntry) (io.ReadCloser, error) {
+	if seg.ChunkNumber < 0 || seg.ChunkNumber >= len(a.chunks) {
+		return nil, fmt.Errorf("bigcompressor: entry %q references unknown chunk %d", seg.Name, seg.ChunkNumber)
+	}
+	chunk := a.chunks[seg.ChunkNumber]
+
+	f, err := os.Open(a.path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(chunk.Offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	br := bufio.NewReader(io.LimitReader(f, chunk.Length))
+	peek, _ := br.Peek(maxCodecMagicLen)
+	codec := sniffCodec(peek)
+	if codec == nil {
+		codec = ZstdCodec{}
+	}
+	zr, err := codec.NewReader(br)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	cr := &countingReader{r: zr}
+	tr := tar.NewReader(cr)
+	var tracker tarOffsetTracker
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			zr.Close()
+			f.Close()
+			return nil, fmt.Errorf("bigcompressor: entry %q missing from chunk %d", seg.Name, seg.ChunkNumber)
+		}
+		if err != nil {
+			zr.Close()
+			f.Close()
+			return nil, err
+		}
+		offset := tracker.next(cr.n, header.Size)
+		if header.Name == seg.Name && offset == seg.TarOffset {
+			return &chunkSegmentReader{tr: tr, zr: zr, f: f}, nil
+		}
+	}
+}
+
+// multiSegmentReader concatenates a file's segments, opening one chunk at a
+// time so memory use stays bounded to a single chunk regardless of how many
+// segments or chunks the file was split across.
+type multiSegmentReader struct {
+	a    *Archive
+	segs []tocEntry
+	idx  int
+	cur  io.ReadCloser
+}
+
+func (r *multiSegmentReader) Read(p []byte) (int, error) {
+	for {
+		if r.cur == nil {
+			if r.idx >= len(r.segs) {
+				return 0, io.EOF
+			}
+			cur, err := r.a.openSegment(r.segs[r.idx])
+			if err != nil {
+				return 0, err
+			}
+			r.cur = cur
+			r.idx++
+		}
+		n, err := r.cur.Read(p)
+		if err == io.EOF {
+			r.cur.Close()
+			r.cur = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (r *multiSegmentReader) Close() error {
+	if r.cur != nil {
+		return r.cur.Close()
+	}
+	return nil
+}
+
+// Open streams name's full content, seeking directly to each of its chunks
+// and decoding only those, each with its sniffed codec, without scanning the
+// rest of the archive.
+func (a *Archive) Open(name string) (io.ReadCloser, error) {
+	segs, ok := a.segments[name]
+	if !ok {
+		return nil, fmt.Errorf("bigcompressor: entry %q not found in archive", name)
+	}
+	return &multiSegmentReader{a: a, segs: segs}, nil
+}
+
+// sanitizeEntryName neutralizes path traversal in name, returning a path
+// guaranteed relative and confined under whatever directory it's later
+// joined onto. Both this package's TOC footer and incoming tar headers are
+// untrusted input by design -- archives built with BuildIndex or consumed
+// through CompressStream/DecompressStream are meant to come from S3, an
+// HTTP body, or a registry -- so a crafted name like "../../../etc/cron.d/x"
+// must not be allowed to land outside the destination directory.
+func sanitizeEntryName(name string) string {
+	cleaned := filepath.Clean(string(filepath.Separator) + name)
+	return strings.TrimPrefix(cleaned, string(filepath.Separator))
+}
+
+// Extract writes name's content out under dstDir, creating parent
+// directories and applying its recorded file mode.
+func (a *Archive) Extract(name, dstDir string) error {
+	segs, ok := a.segments[name]
+	if !ok {
+		return fmt.Errorf("bigcompressor: entry %q not found in archive", name)
+	}
+
+	rc, err := a.Open(name)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	target := filepath.Join(dstDir, sanitizeEntryName(name))
+	if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+		return err
+	}
+	fileToWrite, err := os.OpenFile(target, os.O_CREATE|os.O_RDWR|os.O_TRUNC, os.FileMode(segs[0].Mode))
+	if err != nil {
+		return err
+	}
+	defer fileToWrite.Close()
+
+	buf := make([]byte, 32*1024)
+	_, err = io.CopyBuffer(fileToWrite, rc, buf)
+	return err
+}
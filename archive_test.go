@@ -0,0 +1,80 @@
+package bigcompressor
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestOpenArchiveRoundTripsCompress builds a combined, indexed archive with
+// Compress and confirms OpenArchive's List/Open/Extract reconstruct exactly
+// what was written -- entry count, sizes and content -- so the TOC footer it
+// reads does not diverge from what the compressed chunks actually hold.
+func TestOpenArchiveRoundTripsCompress(t *testing.T) {
+	src := t.TempDir()
+	want := make(map[string][]byte)
+	for i := 0; i < 5; i++ {
+		name := fmt.Sprintf("file%02d.bin", i)
+		data := bytes.Repeat([]byte{byte(i + 1)}, (i+1)*4096)
+		if err := os.WriteFile(filepath.Join(src, name), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		want[name] = data
+	}
+
+	dst := filepath.Join(t.TempDir(), "combined.bin")
+	bc := &BigCompressor{MaxPrecompressChunkSize: 8192, CombineChunk: true, BuildIndex: true}
+	if err := bc.Compress(src, dst); err != nil {
+		t.Fatal(err)
+	}
+
+	arch, err := bc.OpenArchive(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries := arch.List()
+	if len(entries) != len(want) {
+		t.Fatalf("got %d archive entries, want %d", len(entries), len(want))
+	}
+
+	extractDir := t.TempDir()
+	for _, e := range entries {
+		base := strings.TrimPrefix(e.Name, string(filepath.Separator))
+		wantData, ok := want[base]
+		if !ok {
+			t.Fatalf("archive listed unexpected entry %q", e.Name)
+		}
+		if e.Size != int64(len(wantData)) {
+			t.Errorf("entry %q: Size = %d, want %d", e.Name, e.Size, len(wantData))
+		}
+
+		rc, err := arch.Open(e.Name)
+		if err != nil {
+			t.Fatalf("Open(%q): %v", e.Name, err)
+		}
+		var got bytes.Buffer
+		if _, err := got.ReadFrom(rc); err != nil {
+			rc.Close()
+			t.Fatalf("reading %q: %v", e.Name, err)
+		}
+		rc.Close()
+		if !bytes.Equal(got.Bytes(), wantData) {
+			t.Errorf("Open(%q) content mismatch", e.Name)
+		}
+
+		if err := arch.Extract(e.Name, extractDir); err != nil {
+			t.Fatalf("Extract(%q): %v", e.Name, err)
+		}
+		extracted, err := os.ReadFile(filepath.Join(extractDir, base))
+		if err != nil {
+			t.Fatalf("reading extracted %q: %v", e.Name, err)
+		}
+		if !bytes.Equal(extracted, wantData) {
+			t.Errorf("Extract(%q) content mismatch", e.Name)
+		}
+	}
+}
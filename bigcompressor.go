@@ -2,27 +2,79 @@ package bigcompressor
 
 import (
 	"archive/tar"
-	"bufio"
 	"bytes"
+	"context"
+	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
 
-	"github.com/klauspost/compress/zstd"
+	"golang.org/x/sync/errgroup"
 )
 
 var chunkseparator = bytes.NewBufferString("_cHuNK_")
 
+// countingWriter tracks how many bytes have flowed through it so callers can
+// record the offset of a tar header within a chunk's decompressed stream.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
 type BigCompressor struct {
 	MaxPrecompressChunkSize int64
 	MaxDecompressBufferSize int64
 	CombineChunk            bool
-	buffer                  *bytes.Buffer
+	// Parallelism, when greater than 1, compresses distinct chunks concurrently
+	// across that many workers instead of the default sequential path.
+	Parallelism int
+	// BuildIndex, when set together with CombineChunk, appends a table-of-contents
+	// footer to dst so OpenArchive can seek directly to a chunk instead of
+	// scanning the whole file. See archive.go.
+	BuildIndex bool
+	// ContentDefinedChunking, MinChunkSize, MaxChunkSize and AvgChunkSize
+	// split files into segments at content-defined boundaries found with a
+	// rolling hash. Segments are still batched into dataChunks by
+	// MaxPrecompressChunkSize, which callers need not set: left at 0 it
+	// defaults to 64 MiB. A boundary does not start a new dataChunk on its
+	// own. See cdc.go.
+	ContentDefinedChunking bool
+	MinChunkSize           int64
+	MaxChunkSize           int64
+	AvgChunkSize           int64
+	// ChunkStore, when set together with ContentDefinedChunking, lets
+	// Compress skip re-emitting a chunk whose digest a previous run already
+	// Put there. Manifest records which chunks were skipped.
+	ChunkStore ChunkStore
+	Manifest   []ManifestEntry
+	// Codec selects the compression format each chunk is written with,
+	// defaulting to ZstdCodec when nil. Decompress sniffs each chunk's own
+	// magic bytes against the built-in codecs first, so archives written with
+	// one of them still decode correctly even after Codec is changed, and a
+	// combined archive may even mix codecs across chunks. Codec is used to
+	// decode only a chunk that matches none of the built-in magics.
+	Codec Codec
+	// CompressionLevel is forwarded to Codec's writer. Not every codec has a
+	// numeric level; a value of 0 uses that codec's default.
+	CompressionLevel int
+	// OnProgress, when set, is called as CompressContext/DecompressContext
+	// make headway. Byte-count updates are throttled internally (see
+	// progressByteInterval); every finished file also fires it. See Progress.
+	OnProgress func(Progress)
+	buffer     *bytes.Buffer
 
 	ioCPBuffer   []byte
 	compressFile *os.File
+	toc          *tableOfContents
 }
 
 type dataChunk struct {
@@ -34,13 +86,90 @@ type dataChunk struct {
 type fileInfo struct {
 	os.FileInfo
 	file string
+	// fsys is nil for the path-based planners (createChunkInfo,
+	// createContentDefinedChunkInfo), which read file via os.Open. The
+	// fs.FS-based planners in stream.go set it so open reads through srcFS
+	// instead, letting CompressStream work over any fs.FS.
+	fsys fs.FS
+	// segOffset/segLen carve out a byte range of file instead of its whole
+	// content, used by createContentDefinedChunkInfo when a content-defined
+	// boundary falls in the middle of a file. segLen of 0 means "the whole
+	// file", keeping fixed-size chunking unaffected.
+	segOffset int64
+	segLen    int64
+}
+
+// open returns fi's content, reading through fsys when set or the real
+// filesystem otherwise.
+func (fi *fileInfo) open() (io.ReadCloser, error) {
+	if fi.fsys != nil {
+		return fi.fsys.Open(fi.file)
+	}
+	return os.Open(fi.file)
+}
+
+// applySegment overrides header.Size to this fileInfo's segment length (when
+// set) and, for anything but the first segment of a file, records the
+// segment's starting offset as a PAX record so Decompress can seek to the
+// right place instead of overwriting the file from byte zero.
+func (fi *fileInfo) applySegment(header *tar.Header) {
+	if fi.segLen == 0 {
+		return
+	}
+	header.Size = fi.segLen
+	if fi.segOffset > 0 {
+		if header.PAXRecords == nil {
+			header.PAXRecords = map[string]string{}
+		}
+		header.PAXRecords[segmentOffsetPAXRecord] = strconv.FormatInt(fi.segOffset, 10)
+	}
 }
 
+// segmentReader returns the portion of data that this fileInfo represents,
+// seeking to segOffset first when this is not the whole file. Seeking past
+// the first segment of a file requires data to implement io.Seeker, which
+// os.File always does; an fs.FS passed to CompressStream only needs to
+// satisfy that for files content-defined chunking splits into more than one
+// segment.
+func (fi *fileInfo) segmentReader(data io.ReadCloser) (io.Reader, error) {
+	if fi.segLen == 0 {
+		return data, nil
+	}
+	if fi.segOffset > 0 {
+		seeker, ok := data.(io.Seeker)
+		if !ok {
+			return nil, fmt.Errorf("bigcompressor: content-defined chunking needs a seekable file, got %T for %s", data, fi.file)
+		}
+		if _, err := seeker.Seek(fi.segOffset, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+	return io.LimitReader(data, fi.segLen), nil
+}
+
+// Compress is CompressContext with context.Background().
 func (bc *BigCompressor) Compress(src, dst string) error {
+	return bc.CompressContext(context.Background(), src, dst)
+}
+
+// CompressContext is Compress, but checks ctx.Err() between reads -- so
+// cancellation aborts within one io.CopyBuffer buffer's worth of work -- and
+// reports progress through bc.OnProgress, when set.
+func (bc *BigCompressor) CompressContext(ctx context.Context, src, dst string) error {
 	if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
 		return err
 	}
-	dataChunks := bc.createChunkInfo(src)
+	var dataChunks []*dataChunk
+	if bc.ContentDefinedChunking {
+		var err error
+		dataChunks, err = bc.createContentDefinedChunkInfo(src)
+		if err != nil {
+			return err
+		}
+	} else {
+		dataChunks = bc.createChunkInfo(src)
+	}
+	tracker := newProgressTracker(bc.OnProgress, totalBytesOf(dataChunks))
 
 	if bc.CombineChunk {
 		var err error
@@ -50,6 +179,23 @@ func (bc *BigCompressor) Compress(src, dst string) error {
 			return err
 		}
 	}
+
+	if bc.CombineChunk && bc.BuildIndex {
+		bc.toc = &tableOfContents{}
+	} else {
+		bc.toc = nil
+	}
+
+	if bc.Parallelism > 1 && len(dataChunks) > 1 {
+		if err := bc.compressChunksParallel(ctx, src, dst, dataChunks, tracker); err != nil {
+			return err
+		}
+		if bc.toc != nil {
+			return bc.writeFooter()
+		}
+		return nil
+	}
+
 	if bc.buffer == nil {
 		bc.buffer = &bytes.Buffer{}
 	}
@@ -58,10 +204,38 @@ func (bc *BigCompressor) Compress(src, dst string) error {
 		bc.ioCPBuffer = make([]byte, 32*1024)
 	}
 	for _, dChunk = range dataChunks {
-		err := bc.compressChunkNoAlloc(src, dChunk)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		tracker.setChunk(dChunk.chunkNumber)
+		var chunkOffset int64
+		if bc.toc != nil {
+			if pos, err := bc.compressFile.Seek(0, io.SeekCurrent); err == nil {
+				chunkOffset = pos
+			}
+		}
+		entries, err := bc.compressChunkNoAlloc(ctx, tracker, src, dChunk)
+		if err != nil {
+			return err
+		}
+
+		skip, err := bc.dedupChunk(dChunk.chunkNumber, bc.buffer.Bytes())
 		if err != nil {
 			return err
 		}
+		if skip {
+			bc.buffer.Reset()
+			continue
+		}
+
+		if bc.toc != nil {
+			bc.toc.Chunks = append(bc.toc.Chunks, tocChunk{
+				ChunkNumber: dChunk.chunkNumber,
+				Offset:      chunkOffset,
+				Length:      int64(bc.buffer.Len()),
+			})
+			bc.toc.Entries = append(bc.toc.Entries, entries...)
+		}
 		if !bc.CombineChunk {
 			err = bc.writeChunk(dst + "_" + strconv.Itoa(dChunk.chunkNumber))
 			if err != nil {
@@ -75,78 +249,46 @@ func (bc *BigCompressor) Compress(src, dst string) error {
 		}
 		bc.buffer.Reset()
 	}
+	if bc.toc != nil {
+		return bc.writeFooter()
+	}
 	return nil
 }
 
+// Decompress is DecompressContext with context.Background().
 func (bc *BigCompressor) Decompress(src, dst string) error {
-	if bc.buffer == nil {
-		bc.buffer = &bytes.Buffer{}
-	}
-	bc.buffer.Reset()
+	return bc.DecompressContext(context.Background(), src, dst)
+}
+
+// DecompressContext is Decompress, but checks ctx.Err() between reads -- so
+// cancellation aborts within one io.CopyBuffer buffer's worth of work -- and
+// reports progress through bc.OnProgress, when set. TotalBytes is always 0:
+// the combined file's total uncompressed size isn't known without either a
+// BuildIndex footer or a first scanning pass.
+func (bc *BigCompressor) DecompressContext(ctx context.Context, src, dst string) error {
 	f, err := os.Open(src)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
-	if bc.ioCPBuffer == nil {
-		bc.ioCPBuffer = make([]byte, 32*1024)
-	}
-	csBytes := chunkseparator.Bytes()
-	scanner := bufio.NewScanner(f)
-	buf := make([]byte, bc.MaxDecompressBufferSize)
-	scanner.Buffer(buf, bufio.MaxScanTokenSize)
-	scanFn := func(data []byte, atEOF bool) (advance int, token []byte, err error) {
-		commaidx := bytes.Index(data, csBytes)
-		if commaidx > 0 {
-			// we need to return the next position
-			buffer := data[:commaidx]
-			return commaidx + len(csBytes), bytes.TrimSpace(buffer), nil
-		}
-		// if we are at the end of the string, just return the entire buffer
-		if atEOF {
-			// but only do that when there is some data. If not, this might mean
-			// that we've reached the end of our input CSV string
-			if len(data) > 0 {
-				return len(data), bytes.TrimSpace(data), nil
-			}
-		}
-
-		// when 0, nil, nil is returned, this is a signal to the interface to read
-		// more data in from the input reader. In this case, this input is our
-		// string reader and this pretty much will never occur.
-		return 0, nil, nil
-	}
-	scanner.Split(scanFn)
-	for scanner.Scan() {
-		n := scanner.Bytes()
-		if len(n) > 10 {
-			_, err = bc.buffer.Write(n)
-			if err != nil {
-				return err
-			}
-			err = bc.decompressChunk(dst)
-			if err != nil {
-				return err
-			}
-			bc.buffer.Reset()
-		}
-
-	}
-	return nil
+	return bc.DecompressStream(ctx, f, osWritableFS{dir: dst})
 }
 
-var zstdDecode *zstd.Decoder
-var tarDecode *tar.Reader
-
-func (bc BigCompressor) decompressChunk(dst string) error {
-	if zstdDecode == nil {
-		zstdDecode, _ = zstd.NewReader(bc.buffer)
-		tarDecode = tar.NewReader(zstdDecode)
-	} else {
-		zstdDecode.Reset(bc.buffer)
+func (bc BigCompressor) decompressChunk(ctx context.Context, tracker *progressTracker, dstFS WritableFS) error {
+	// Sniff the chunk's own magic bytes rather than trusting bc.Codec, so a
+	// combined archive decodes correctly even if it mixes codecs across
+	// chunks or was produced by an older version defaulting to plain zstd.
+	codec := sniffCodec(bc.buffer.Bytes())
+	if codec == nil {
+		codec = bc.codec()
 	}
+	codecDecode, err := codec.NewReader(bc.buffer)
+	if err != nil {
+		return err
+	}
+	defer codecDecode.Close()
+	tarDecode := tar.NewReader(codecDecode)
 
-	var target, dirName string
 	for {
 		header, err := tarDecode.Next()
 		if err == io.EOF {
@@ -155,30 +297,49 @@ func (bc BigCompressor) decompressChunk(dst string) error {
 		if err != nil {
 			return err
 		}
-		target = filepath.Join(dst, header.Name)
 
 		// check the type
 		switch header.Typeflag {
 		// if it's a file create it
 		case tar.TypeReg:
-			dirName = filepath.Dir(target)
-			if _, err = os.Stat(dirName); err != nil {
-				err = os.MkdirAll(dirName, 0700)
-				if err != nil {
-					panic(err)
-				}
+			// header.Name is untrusted input -- DecompressStream exists so
+			// archives can come from S3, an HTTP body, or anywhere else --
+			// so neutralize any ".." or absolute path it might carry before
+			// it reaches dstFS.
+			name := sanitizeEntryName(header.Name)
+			if err := dstFS.MkdirAll(filepath.Dir(name), 0700); err != nil {
+				return err
 			}
-			fileToWrite, err := os.OpenFile(target, os.O_CREATE|os.O_RDWR, os.FileMode(header.Mode))
+			fileToWrite, err := dstFS.OpenFile(name, os.O_CREATE|os.O_RDWR, os.FileMode(header.Mode))
 			if err != nil {
 				return err
 			}
+			// content-defined chunking can split one file's content across several
+			// tar entries; later entries carry their starting offset as a PAX record
+			// so they land back in the right place instead of overwriting from byte 0.
+			if off, ok := header.PAXRecords[segmentOffsetPAXRecord]; ok {
+				offset, err := strconv.ParseInt(off, 10, 64)
+				if err != nil {
+					fileToWrite.Close()
+					return err
+				}
+				if _, err := fileToWrite.Seek(offset, io.SeekStart); err != nil {
+					fileToWrite.Close()
+					return err
+				}
+			}
 			// copy over contents
-			if _, err = io.CopyBuffer(fileToWrite, tarDecode, bc.ioCPBuffer); err != nil {
+			cr := &ctxCountingReader{ctx: ctx, r: tarDecode, report: tracker.addBytesRead}
+			n, err := io.CopyBuffer(fileToWrite, cr, bc.ioCPBuffer)
+			if err != nil {
+				fileToWrite.Close()
 				return err
 			}
+			tracker.addBytesWritten(n)
 			// manually close here after each file operation; defering would cause each file close
 			// to wait until all operations have completed.
 			fileToWrite.Close()
+			tracker.fileDone(header.Name)
 		}
 	}
 	return nil
@@ -293,49 +454,298 @@ func (bc BigCompressor) createChunkInfo(src string) []*dataChunk {
 	return dataChunks
 }
 
-var zstdEncode *zstd.Encoder
-var tarEncode *tar.Writer
+// chunkEncoder holds the per-worker state (codec, buffer and I/O copy
+// buffer) that the sequential path keeps on BigCompressor (bc.buffer,
+// bc.ioCPBuffer, and a codec writer compressChunkNoAlloc creates per call).
+// Parallel workers each own one so they never share state.
+type chunkEncoder struct {
+	codec      Codec
+	level      int
+	buffer     *bytes.Buffer
+	ioCPBuffer []byte
+}
 
-func (bc *BigCompressor) compressChunkNoAlloc(src string, chunk *dataChunk) error {
-	if zstdEncode == nil {
-		zstdEncode, _ = zstd.NewWriter(bc.buffer)
-		tarEncode = tar.NewWriter(zstdEncode)
-	} else {
-		zstdEncode.Reset(bc.buffer)
+func newChunkEncoder(codec Codec, level int) (*chunkEncoder, error) {
+	return &chunkEncoder{
+		codec:      codec,
+		level:      level,
+		buffer:     &bytes.Buffer{},
+		ioCPBuffer: make([]byte, 32*1024),
+	}, nil
+}
+
+// compress encodes chunk into enc's buffer. When buildIndex is set it also
+// returns a tocEntry per regular file, with TarOffset relative to the start
+// of this chunk's (decompressed) tar stream.
+func (enc *chunkEncoder) compress(ctx context.Context, tracker *progressTracker, src string, chunk *dataChunk, buildIndex bool) ([]tocEntry, error) {
+	enc.buffer.Reset()
+	codecEncode, err := enc.codec.NewWriter(enc.buffer, enc.level)
+	if err != nil {
+		return nil, err
+	}
+	counter := &countingWriter{w: codecEncode}
+	tarw := tar.NewWriter(counter)
+
+	var entries []tocEntry
+	var fi *fileInfo
+	for _, fi = range chunk.files {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		header, err := tar.FileInfoHeader(fi, fi.file)
+		if err != nil {
+			return nil, err
+		}
+		header.Name = strings.Replace(fi.file, src, "", 1)
+		fi.applySegment(header)
+
+		tarOffset := counter.n
+		if err := tarw.WriteHeader(header); err != nil {
+			return nil, err
+		}
+		if buildIndex && !fi.IsDir() {
+			entries = append(entries, tocEntry{
+				Name:        header.Name,
+				ChunkNumber: chunk.chunkNumber,
+				TarOffset:   tarOffset,
+				Size:        header.Size,
+				Mode:        int64(fi.Mode()),
+			})
+		}
+		if !fi.IsDir() {
+			data, err := fi.open()
+			if err != nil {
+				return nil, err
+			}
+			r, err := fi.segmentReader(data)
+			var n int64
+			if err == nil {
+				cr := &ctxCountingReader{ctx: ctx, r: r, report: tracker.addBytesRead}
+				n, err = io.CopyBuffer(tarw, cr, enc.ioCPBuffer)
+			}
+			data.Close()
+			if err != nil {
+				return nil, err
+			}
+			tracker.addBytesWritten(n)
+			// tar.Writer defers a file's zero-padding until the next WriteHeader
+			// or Close call, so without this, tarOffset above for the entry that
+			// follows would be read before its own preceding padding landed.
+			if err := tarw.Flush(); err != nil {
+				return nil, err
+			}
+		}
+		tracker.fileDone(header.Name)
+	}
+
+	if err := tarw.Flush(); err != nil {
+		return nil, err
+	}
+	if err := codecEncode.Close(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// compressChunksParallel dispatches compressChunkNoAlloc-equivalent work for each
+// dataChunk across bc.Parallelism workers, each owning its own chunkEncoder. A
+// serializer goroutine drains the per-chunk results in chunkNumber order so the
+// output is byte-for-byte the same as the sequential path regardless of which
+// worker finishes a given chunk first.
+type chunkResult struct {
+	data    []byte
+	entries []tocEntry
+}
+
+func (bc *BigCompressor) compressChunksParallel(ctx context.Context, src, dst string, dataChunks []*dataChunk, tracker *progressTracker) error {
+	results := make([]chan chunkResult, len(dataChunks))
+	for i := range results {
+		results[i] = make(chan chunkResult, 1)
+	}
+
+	g, gctx := errgroup.WithContext(ctx)
+	chunks := make(chan *dataChunk)
+	buildIndex := bc.toc != nil
+
+	workers := bc.Parallelism
+	if workers > len(dataChunks) {
+		workers = len(dataChunks)
+	}
+	codec, level := bc.codec(), bc.CompressionLevel
+	for i := 0; i < workers; i++ {
+		g.Go(func() error {
+			enc, err := newChunkEncoder(codec, level)
+			if err != nil {
+				return err
+			}
+			for dChunk := range chunks {
+				tracker.setChunk(dChunk.chunkNumber)
+				entries, err := enc.compress(gctx, tracker, src, dChunk, buildIndex)
+				if err != nil {
+					return err
+				}
+				out := make([]byte, enc.buffer.Len())
+				copy(out, enc.buffer.Bytes())
+				enc.buffer.Reset()
+				results[dChunk.chunkNumber] <- chunkResult{data: out, entries: entries}
+			}
+			return nil
+		})
 	}
+
+	g.Go(func() error {
+		defer close(chunks)
+		for _, dChunk := range dataChunks {
+			select {
+			case chunks <- dChunk:
+			case <-gctx.Done():
+				return gctx.Err()
+			}
+		}
+		return nil
+	})
+
+	serializeErr := make(chan error, 1)
+	go func() {
+		var offset int64
+		if buildIndex {
+			if pos, err := bc.compressFile.Seek(0, io.SeekCurrent); err == nil {
+				offset = pos
+			}
+		}
+		for i, res := range results {
+			// A worker that returns an error never sends on its chunk's
+			// result channel, and errgroup.WithContext cancels gctx as soon
+			// as that happens -- so without a fallback on gctx.Done(), a
+			// failed run would block here forever instead of returning
+			// gctx.Err() and leaking this goroutine for the life of the
+			// process. The non-blocking receive is tried first because
+			// errgroup also cancels gctx the instant g.Wait returns
+			// *successfully* -- on the happy path every res is already
+			// buffered by the time this goroutine reaches it, and without
+			// priority on the data case, select could still randomly pick
+			// the now-closed gctx.Done() over a result that arrived just
+			// fine.
+			var r chunkResult
+			select {
+			case r = <-res:
+			default:
+				select {
+				case r = <-res:
+				case <-gctx.Done():
+					serializeErr <- gctx.Err()
+					return
+				}
+			}
+
+			skip, err := bc.dedupChunk(i, r.data)
+			if err != nil {
+				serializeErr <- err
+				return
+			}
+			if skip {
+				continue
+			}
+
+			if !bc.CombineChunk {
+				err = os.WriteFile(dst+"_"+strconv.Itoa(i), r.data, 0700)
+			} else {
+				if buildIndex {
+					bc.toc.Chunks = append(bc.toc.Chunks, tocChunk{
+						ChunkNumber: i,
+						Offset:      offset,
+						Length:      int64(len(r.data)),
+					})
+					bc.toc.Entries = append(bc.toc.Entries, r.entries...)
+					offset += int64(len(r.data)) + int64(chunkseparator.Len())
+				}
+				if _, err = bc.compressFile.Write(r.data); err == nil {
+					_, err = bc.compressFile.Write(chunkseparator.Bytes())
+				}
+			}
+			if err != nil {
+				serializeErr <- err
+				return
+			}
+		}
+		serializeErr <- nil
+	}()
+
+	if err := g.Wait(); err != nil {
+		return err
+	}
+	return <-serializeErr
+}
+
+func (bc *BigCompressor) compressChunkNoAlloc(ctx context.Context, tracker *progressTracker, src string, chunk *dataChunk) ([]tocEntry, error) {
+	codecEncode, err := bc.codec().NewWriter(bc.buffer, bc.CompressionLevel)
+	if err != nil {
+		return nil, err
+	}
+	tarByteCounter := &countingWriter{w: codecEncode}
+	tarEncode := tar.NewWriter(tarByteCounter)
+	var entries []tocEntry
 	var fi *fileInfo
 	for _, fi = range chunk.files {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		header, err := tar.FileInfoHeader(fi, fi.file)
 
 		if err != nil {
-			return err
+			return nil, err
 		}
 		header.Name = strings.Replace(fi.file, src, "", 1)
+		fi.applySegment(header)
 
+		tarOffset := tarByteCounter.n
 		// write header
 		if err := tarEncode.WriteHeader(header); err != nil {
-			return err
+			return nil, err
+		}
+		if bc.toc != nil && !fi.IsDir() {
+			entries = append(entries, tocEntry{
+				Name:        header.Name,
+				ChunkNumber: chunk.chunkNumber,
+				TarOffset:   tarOffset,
+				Size:        header.Size,
+				Mode:        int64(fi.Mode()),
+			})
 		}
 		// if not a dir, write file content
 		if !fi.IsDir() {
-			data, err := os.Open(fi.file)
+			data, err := fi.open()
 			if err != nil {
-				return err
+				return nil, err
 			}
-			if _, err := io.CopyBuffer(tarEncode, data, bc.ioCPBuffer); err != nil {
-				return err
+			r, err := fi.segmentReader(data)
+			var n int64
+			if err == nil {
+				cr := &ctxCountingReader{ctx: ctx, r: r, report: tracker.addBytesRead}
+				n, err = io.CopyBuffer(tarEncode, cr, bc.ioCPBuffer)
 			}
 			data.Close()
+			if err != nil {
+				return nil, err
+			}
+			tracker.addBytesWritten(n)
+			// tar.Writer defers a file's zero-padding until the next WriteHeader
+			// or Close call, so without this, tarOffset above for the entry that
+			// follows would be read before its own preceding padding landed.
+			if err := tarEncode.Flush(); err != nil {
+				return nil, err
+			}
 		}
+		tracker.fileDone(header.Name)
 	}
 
 	// produce tar
 	if err := tarEncode.Flush(); err != nil {
-		return err
+		return nil, err
 	}
-	// produce gzip
-	if err := zstdEncode.Close(); err != nil {
-		return err
+	// produce codec trailer (e.g. zstd frame epilogue)
+	if err := codecEncode.Close(); err != nil {
+		return nil, err
 	}
-	return nil
+	return entries, nil
 }
@@ -0,0 +1,85 @@
+package bigcompressor
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeTestFiles(t *testing.T, dir string, n int, size int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		data := bytes.Repeat([]byte{byte(i + 1)}, size)
+		name := filepath.Join(dir, fmt.Sprintf("f%02d.bin", i))
+		if err := os.WriteFile(name, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestCompressParallelMatchesSequential guards compressChunksParallel's whole
+// reason for existing: regardless of which worker finishes a given chunk
+// first, the serializer must still write chunks out in chunkNumber order, so
+// a combined archive built with Parallelism>1 is byte-for-byte identical to
+// one built sequentially.
+func TestCompressParallelMatchesSequential(t *testing.T) {
+	src := t.TempDir()
+	writeTestFiles(t, src, 12, 50*1024)
+
+	seqDst := filepath.Join(t.TempDir(), "seq.bin")
+	bcSeq := &BigCompressor{MaxPrecompressChunkSize: 64 * 1024, CombineChunk: true}
+	if err := bcSeq.Compress(src, seqDst); err != nil {
+		t.Fatal(err)
+	}
+
+	parDst := filepath.Join(t.TempDir(), "par.bin")
+	bcPar := &BigCompressor{MaxPrecompressChunkSize: 64 * 1024, CombineChunk: true, Parallelism: 4}
+	if err := bcPar.Compress(src, parDst); err != nil {
+		t.Fatal(err)
+	}
+
+	seqBytes, err := os.ReadFile(seqDst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parBytes, err := os.ReadFile(parDst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(seqBytes, parBytes) {
+		t.Fatalf("parallel output diverged from sequential: %d bytes vs %d bytes", len(parBytes), len(seqBytes))
+	}
+}
+
+// TestCompressParallelReturnsErrorOnWorkerFailure makes a worker fail on a
+// dangling symlink and checks Compress returns that error promptly. This is
+// the case where the serializer goroutine used to block forever on the failed
+// chunk's result channel, since nothing ever sends on it; Compress still
+// returned (g.Wait doesn't wait for the serializer), so the test does not
+// observe that goroutine itself. A select on gctx.Done() lets the serializer
+// bail out instead.
+func TestCompressParallelReturnsErrorOnWorkerFailure(t *testing.T) {
+	src := t.TempDir()
+	writeTestFiles(t, src, 6, 50*1024)
+	if err := os.Symlink(filepath.Join(src, "does-not-exist"), filepath.Join(src, "dangling")); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := filepath.Join(t.TempDir(), "out.bin")
+	bc := &BigCompressor{MaxPrecompressChunkSize: 64 * 1024, CombineChunk: true, Parallelism: 4}
+
+	done := make(chan error, 1)
+	go func() { done <- bc.Compress(src, dst) }()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("expected an error from the dangling symlink, got nil")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Compress did not return after a worker failed")
+	}
+}
@@ -0,0 +1,240 @@
+package bigcompressor
+
+import (
+	"crypto/sha256"
+	"io"
+	"math/bits"
+	"os"
+	"path/filepath"
+)
+
+// segmentOffsetPAXRecord is the PAX header key used to carry a file segment's
+// starting byte offset when content-defined chunking splits that file's
+// content across more than one tar entry.
+const segmentOffsetPAXRecord = "BIGC.offset"
+
+const (
+	defaultMinChunkSize = 256 * 1024
+	defaultAvgChunkSize = 4 * 1024 * 1024
+	defaultMaxChunkSize = 16 * 1024 * 1024
+	rollingWindow       = 64
+
+	// defaultMaxPrecompressChunkSize is the dataChunk batching threshold the
+	// content-defined planners fall back to when MaxPrecompressChunkSize is
+	// left unset, since ContentDefinedChunking's own doc comment says
+	// callers need not set that field: leaving it at its zero value must
+	// still batch several content-defined segments into each dataChunk
+	// rather than cutting a new one after every single segment.
+	defaultMaxPrecompressChunkSize = 64 * 1024 * 1024
+)
+
+func (bc *BigCompressor) minChunkSize() int64 {
+	if bc.MinChunkSize > 0 {
+		return bc.MinChunkSize
+	}
+	return defaultMinChunkSize
+}
+
+func (bc *BigCompressor) avgChunkSize() int64 {
+	if bc.AvgChunkSize > 0 {
+		return bc.AvgChunkSize
+	}
+	return defaultAvgChunkSize
+}
+
+func (bc *BigCompressor) maxChunkSize() int64 {
+	if bc.MaxChunkSize > 0 {
+		return bc.MaxChunkSize
+	}
+	return defaultMaxChunkSize
+}
+
+// maxPrecompressChunkSize is bc.MaxPrecompressChunkSize, defaulting when
+// unset so the content-defined planners still batch multiple segments per
+// dataChunk instead of cutting one after every boundary. See
+// defaultMaxPrecompressChunkSize.
+func (bc *BigCompressor) maxPrecompressChunkSize() int64 {
+	if bc.MaxPrecompressChunkSize > 0 {
+		return bc.MaxPrecompressChunkSize
+	}
+	return defaultMaxPrecompressChunkSize
+}
+
+// rollingHashTable is a fixed pseudo-random table indexed by byte value, the
+// same role table[] plays in a classic Buzhash.
+var rollingHashTable = func() [256]uint64 {
+	var t [256]uint64
+	seed := uint64(0x9E3779B97F4A7C15)
+	for i := range t {
+		seed = seed*6364136223846793005 + 1442695040888963407
+		t[i] = seed
+	}
+	return t
+}()
+
+func rotl64(x uint64, k uint) uint64 {
+	k %= 64
+	if k == 0 {
+		return x
+	}
+	return (x << k) | (x >> (64 - k))
+}
+
+// rollingHash is a Buzhash-style rolling hash over a fixed-size byte window.
+// Content-defined chunk boundaries are found by rolling this hash over a
+// file's bytes and cutting wherever it matches a mask: inserting or deleting
+// bytes in the file then only perturbs the hash (and the boundary it may
+// produce) near the edit, leaving chunk boundaries elsewhere in the file, and
+// in every other file, unaffected.
+type rollingHash struct {
+	window [rollingWindow]byte
+	pos    int
+	h      uint64
+}
+
+func (rh *rollingHash) roll(in byte) uint64 {
+	out := rh.window[rh.pos]
+	rh.window[rh.pos] = in
+	rh.pos = (rh.pos + 1) % rollingWindow
+	rh.h = rotl64(rh.h, 1) ^ rollingHashTable[in] ^ rotl64(rollingHashTable[out], rollingWindow)
+	return rh.h
+}
+
+// cdcMask turns an average chunk size into the bitmask rollingHash results
+// are compared against: a boundary occurs on average every 1<<bits bytes.
+func cdcMask(avgSize int64) uint64 {
+	if avgSize < 2 {
+		avgSize = 2
+	}
+	return uint64(1)<<uint(bits.Len64(uint64(avgSize))-1) - 1
+}
+
+const cdcMagic = 0
+
+// ChunkStore lets successive Compress runs with ContentDefinedChunking
+// recognize a content-defined chunk they have already emitted and skip
+// re-emitting it. digest is the SHA-256 of the chunk's compressed bytes.
+type ChunkStore interface {
+	Has(digest [32]byte) (bool, error)
+	Put(digest [32]byte, data []byte) error
+}
+
+// ManifestEntry records, for one emitted dataChunk, whether dedupChunk found
+// it already present in ChunkStore and skipped writing it to dst.
+type ManifestEntry struct {
+	ChunkNumber int
+	Digest      [32]byte
+	Size        int64
+	Skipped     bool
+}
+
+// dedupChunk checks data (a fully compressed chunk) against bc.ChunkStore,
+// recording the outcome in bc.Manifest. It is a no-op returning skip=false
+// when no ChunkStore is configured.
+func (bc *BigCompressor) dedupChunk(chunkNumber int, data []byte) (skip bool, err error) {
+	if bc.ChunkStore == nil {
+		return false, nil
+	}
+	digest := sha256.Sum256(data)
+	exists, err := bc.ChunkStore.Has(digest)
+	if err != nil {
+		return false, err
+	}
+	bc.Manifest = append(bc.Manifest, ManifestEntry{
+		ChunkNumber: chunkNumber,
+		Digest:      digest,
+		Size:        int64(len(data)),
+		Skipped:     exists,
+	})
+	if exists {
+		return true, nil
+	}
+	return false, bc.ChunkStore.Put(digest, data)
+}
+
+// createContentDefinedChunkInfo walks src like createChunkInfo, but rolls a
+// hash over every regular file's bytes and ends a segment there, even
+// mid-file, whenever the hash matches a boundary and the segment built up so
+// far in that file is at least MinChunkSize, or once it reaches MaxChunkSize
+// regardless of the hash. A file that straddles a boundary is represented as
+// two or more fileInfo segments (see fileInfo.segOffset). A boundary does not
+// by itself start a new dataChunk: segments are appended to the current
+// dataChunk, and a new one is cut only after a segment brings its total to
+// MaxPrecompressChunkSize (see maxPrecompressChunkSize) or more.
+func (bc *BigCompressor) createContentDefinedChunkInfo(src string) ([]*dataChunk, error) {
+	mask := cdcMask(bc.avgChunkSize())
+	minSize := bc.minChunkSize()
+	maxSize := bc.maxChunkSize()
+	maxPrecompress := bc.maxPrecompressChunkSize()
+
+	dataChunks := []*dataChunk{{chunkNumber: 0, files: []*fileInfo{}}}
+	currentChunk := 0
+	cut := func() {
+		currentChunk++
+		dataChunks = append(dataChunks, &dataChunk{chunkNumber: currentChunk, files: []*fileInfo{}})
+	}
+
+	readBuf := make([]byte, 32*1024)
+
+	err := filepath.Walk(src, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		chunk := dataChunks[currentChunk]
+
+		if fi.IsDir() || fi.Size() == 0 {
+			chunk.files = append(chunk.files, &fileInfo{file: file, FileInfo: fi})
+			return nil
+		}
+
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		var rh rollingHash
+		var segStart, pos int64
+		appendSegment := func(end int64) {
+			segLen := end - segStart
+			chunk.files = append(chunk.files, &fileInfo{
+				file:      file,
+				FileInfo:  fi,
+				segOffset: segStart,
+				segLen:    segLen,
+			})
+			chunk.totalSize += segLen
+			segStart = end
+			if chunk.totalSize >= maxPrecompress {
+				cut()
+				chunk = dataChunks[currentChunk]
+			}
+		}
+
+		for {
+			n, rerr := f.Read(readBuf)
+			for i := 0; i < n; i++ {
+				h := rh.roll(readBuf[i])
+				pos++
+				segLen := pos - segStart
+				if segLen >= maxSize || (segLen >= minSize && h&mask == cdcMagic) {
+					appendSegment(pos)
+				}
+			}
+			if rerr == io.EOF {
+				break
+			}
+			if rerr != nil {
+				return rerr
+			}
+		}
+		if pos > segStart {
+			appendSegment(pos)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dataChunks, nil
+}
@@ -0,0 +1,114 @@
+package bigcompressor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestContentDefinedChunkingBatchesSegmentsByDefault guards against the
+// regression where leaving MaxPrecompressChunkSize unset -- exactly what
+// ContentDefinedChunking's own doc comment tells callers they can do --
+// caused every single content-defined segment to land in its own dataChunk.
+func TestContentDefinedChunkingBatchesSegmentsByDefault(t *testing.T) {
+	src := t.TempDir()
+	for i := 0; i < 20; i++ {
+		data := make([]byte, 4096)
+		for j := range data {
+			data[j] = byte(i*31 + j)
+		}
+		name := filepath.Join(src, fmt.Sprintf("f%02d.bin", i))
+		if err := os.WriteFile(name, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bc := &BigCompressor{ContentDefinedChunking: true, MinChunkSize: 1024, AvgChunkSize: 4096, MaxChunkSize: 16384}
+	chunks, err := bc.createContentDefinedChunkInfo(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) >= 20 {
+		t.Fatalf("expected multiple files batched per dataChunk with MaxPrecompressChunkSize left unset, got %d dataChunks for 20 files", len(chunks))
+	}
+}
+
+// segmentBoundaries writes data as a single file and returns the cumulative
+// byte offset each content-defined segment ends at.
+func segmentBoundaries(t *testing.T, data []byte) []int64 {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "f.bin"), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	bc := &BigCompressor{ContentDefinedChunking: true, MinChunkSize: 1024, AvgChunkSize: 4096, MaxChunkSize: 16384}
+	chunks, err := bc.createContentDefinedChunkInfo(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var bounds []int64
+	var pos int64
+	for _, c := range chunks {
+		for _, fi := range c.files {
+			if fi.segLen == 0 {
+				continue
+			}
+			pos += fi.segLen
+			bounds = append(bounds, pos)
+		}
+	}
+	return bounds
+}
+
+// TestContentDefinedChunkBoundariesStableUnderSmallEdit confirms the whole
+// point of content-defined chunking over fixed-size chunking: inserting a
+// few bytes in the middle of a file only perturbs the rolling hash (and the
+// boundaries it produces) near the edit, leaving boundaries well before it
+// unchanged, since they depend only on the rollingWindow bytes that precede
+// them.
+func TestContentDefinedChunkBoundariesStableUnderSmallEdit(t *testing.T) {
+	base := make([]byte, 256*1024)
+	for i := range base {
+		base[i] = byte(i * 7)
+	}
+	original := segmentBoundaries(t, base)
+
+	const editAt = 100000
+	const editLen = 37
+	edited := make([]byte, len(base)+editLen)
+	copy(edited, base[:editAt])
+	for i := editAt; i < editAt+editLen; i++ {
+		edited[i] = 0xAA
+	}
+	copy(edited[editAt+editLen:], base[editAt:])
+	after := segmentBoundaries(t, edited)
+
+	// Boundaries this far before the edit can't have seen it yet through the
+	// rolling window (rollingWindow is 64 bytes), so they must survive
+	// unchanged in the edited file's boundary list.
+	const safeMargin = 10000
+	afterSet := make(map[int64]bool, len(after))
+	for _, b := range after {
+		afterSet[b] = true
+	}
+
+	var checked, kept int
+	for _, b := range original {
+		if b >= editAt-safeMargin {
+			continue
+		}
+		checked++
+		if afterSet[b] {
+			kept++
+		}
+	}
+	if checked == 0 {
+		t.Fatal("test setup produced no boundaries before the edit to check -- adjust sizes")
+	}
+	if kept != checked {
+		t.Fatalf("%d/%d boundaries before the edit point were not preserved after a small unrelated edit", checked-kept, checked)
+	}
+}
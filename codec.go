@@ -0,0 +1,125 @@
+package bigcompressor
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+
+	"github.com/dsnet/compress/bzip2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// Codec lets BigCompressor plug in a compression format other than its
+// default of zstd. NewWriter/NewReader wrap a chunk's stream in that format;
+// Magic returns the byte sequence that identifies it. Decompress sniffs a
+// chunk's leading bytes against the Magic of each builtinCodecs entry, so
+// archives built with different built-in codecs -- or even a mix of them
+// across chunks -- still decode transparently. A Codec outside
+// builtinCodecs is not sniffed; it decodes only through BigCompressor.Codec.
+type Codec interface {
+	NewWriter(w io.Writer, level int) (io.WriteCloser, error)
+	NewReader(r io.Reader) (io.ReadCloser, error)
+	Magic() []byte
+}
+
+// ZstdCodec is BigCompressor's default Codec. A level of 0 uses zstd's
+// default encoder level.
+type ZstdCodec struct{}
+
+func (ZstdCodec) NewWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	var opts []zstd.EOption
+	if level > 0 {
+		opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+	}
+	return zstd.NewWriter(w, opts...)
+}
+
+func (ZstdCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	zr, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return zr.IOReadCloser(), nil
+}
+
+func (ZstdCodec) Magic() []byte { return []byte{0x28, 0xB5, 0x2F, 0xFD} }
+
+// GzipCodec compresses chunks with gzip instead of zstd.
+type GzipCodec struct{}
+
+func (GzipCodec) NewWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	if level <= 0 {
+		level = gzip.DefaultCompression
+	}
+	return gzip.NewWriterLevel(w, level)
+}
+
+func (GzipCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+func (GzipCodec) Magic() []byte { return []byte{0x1F, 0x8B} }
+
+// XzCodec compresses chunks with xz instead of zstd. xz has no notion of a
+// simple numeric level in the package this wraps, so level is ignored.
+type XzCodec struct{}
+
+func (XzCodec) NewWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	return xz.NewWriter(w)
+}
+
+func (XzCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	xr, err := xz.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(xr), nil
+}
+
+func (XzCodec) Magic() []byte { return []byte{0xFD, 0x37, 0x7A, 0x58, 0x5A, 0x00} }
+
+// Bzip2Codec compresses chunks with bzip2 instead of zstd.
+type Bzip2Codec struct{}
+
+func (Bzip2Codec) NewWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	return bzip2.NewWriter(w, &bzip2.WriterConfig{Level: level})
+}
+
+func (Bzip2Codec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	br, err := bzip2.NewReader(r, nil)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(br), nil
+}
+
+func (Bzip2Codec) Magic() []byte { return []byte{0x42, 0x5A, 0x68} }
+
+// builtinCodecs lists the codecs sniffCodec checks a chunk's leading bytes
+// against on Decompress, so a chunk's codec need not match bc.Codec.
+var builtinCodecs = []Codec{ZstdCodec{}, GzipCodec{}, XzCodec{}, Bzip2Codec{}}
+
+// maxCodecMagicLen is the number of leading bytes callers need to Peek to
+// sniff against every builtinCodecs entry.
+const maxCodecMagicLen = 6
+
+// sniffCodec returns the builtin Codec whose Magic prefixes data, or nil if
+// none match.
+func sniffCodec(data []byte) Codec {
+	for _, c := range builtinCodecs {
+		magic := c.Magic()
+		if len(data) >= len(magic) && bytes.Equal(data[:len(magic)], magic) {
+			return c
+		}
+	}
+	return nil
+}
+
+// codec returns bc.Codec, defaulting to ZstdCodec when unset.
+func (bc *BigCompressor) codec() Codec {
+	if bc.Codec != nil {
+		return bc.Codec
+	}
+	return ZstdCodec{}
+}
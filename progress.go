@@ -0,0 +1,140 @@
+package bigcompressor
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// Progress reports how far a CompressContext/DecompressContext run has
+// gotten. Fields are cumulative across the whole run, not just the current
+// chunk or file.
+type Progress struct {
+	BytesRead      int64
+	BytesWritten   int64
+	FilesProcessed int64
+	TotalBytes     int64
+	CurrentFile    string
+	ChunkNumber    int
+}
+
+// Byte-count progress callbacks are throttled to at most once per
+// progressByteInterval bytes or progressTimeInterval elapsed, whichever
+// comes first. Finished files are not throttled: fileDone fires on each one.
+const (
+	progressByteInterval = 64 * 1024
+	progressTimeInterval = 100 * time.Millisecond
+)
+
+// progressTracker accumulates Progress across a whole Compress/Decompress
+// run and throttles calls to the configured OnProgress callback. It is safe
+// for concurrent use so compressChunksParallel's workers can share one.
+type progressTracker struct {
+	onProgress func(Progress)
+
+	mu        sync.Mutex
+	p         Progress
+	lastFire  time.Time
+	lastBytes int64
+}
+
+func newProgressTracker(onProgress func(Progress), totalBytes int64) *progressTracker {
+	return &progressTracker{onProgress: onProgress, p: Progress{TotalBytes: totalBytes}}
+}
+
+func (t *progressTracker) addBytesRead(n int64) {
+	if t == nil || t.onProgress == nil {
+		return
+	}
+	t.mu.Lock()
+	t.p.BytesRead += n
+	t.maybeFireLocked()
+	t.mu.Unlock()
+}
+
+func (t *progressTracker) addBytesWritten(n int64) {
+	if t == nil || t.onProgress == nil {
+		return
+	}
+	t.mu.Lock()
+	t.p.BytesWritten += n
+	t.maybeFireLocked()
+	t.mu.Unlock()
+}
+
+// fileDone marks one more file (or directory entry) as processed and fires
+// unconditionally, so CurrentFile always reflects the most recently finished
+// entry rather than waiting for the next byte-count threshold.
+func (t *progressTracker) fileDone(name string) {
+	if t == nil || t.onProgress == nil {
+		return
+	}
+	t.mu.Lock()
+	t.p.FilesProcessed++
+	t.p.CurrentFile = name
+	t.fireLocked()
+	t.mu.Unlock()
+}
+
+func (t *progressTracker) setChunk(n int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.p.ChunkNumber = n
+	t.mu.Unlock()
+}
+
+func (t *progressTracker) maybeFireLocked() {
+	sinceBytes := t.p.BytesRead + t.p.BytesWritten - t.lastBytes
+	if sinceBytes >= progressByteInterval || time.Since(t.lastFire) >= progressTimeInterval {
+		t.fireLocked()
+	}
+}
+
+func (t *progressTracker) fireLocked() {
+	t.onProgress(t.p)
+	t.lastFire = time.Now()
+	t.lastBytes = t.p.BytesRead + t.p.BytesWritten
+}
+
+// ctxCountingReader wraps r, checking ctx.Err() and reporting bytes read to
+// report (when non-nil) between every Read call, so both cancellation and
+// progress reporting land within one io.CopyBuffer buffer's worth of work.
+type ctxCountingReader struct {
+	ctx    context.Context
+	r      io.Reader
+	report func(int64)
+}
+
+func (cr *ctxCountingReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := cr.r.Read(p)
+	if n > 0 && cr.report != nil {
+		cr.report(int64(n))
+	}
+	return n, err
+}
+
+// totalBytesOf sums the content size dataChunks will copy, following
+// fileInfo's own notion of segment length so content-defined chunking's
+// mid-file cuts are counted once each rather than once per whole file.
+func totalBytesOf(dataChunks []*dataChunk) int64 {
+	var total int64
+	for _, chunk := range dataChunks {
+		for _, fi := range chunk.files {
+			if fi.IsDir() {
+				continue
+			}
+			if fi.segLen > 0 {
+				total += fi.segLen
+			} else {
+				total += fi.Size()
+			}
+		}
+	}
+	return total
+}
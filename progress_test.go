@@ -0,0 +1,134 @@
+package bigcompressor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestCompressContextCancelsMidCopy confirms CompressContext's doc-promised
+// behavior -- cancellation aborts within one io.CopyBuffer buffer's worth of
+// work -- actually holds: it cancels the context partway through a single
+// large file's copy (driven by ctxCountingReader, via OnProgress) and checks
+// CompressContext returns the context error promptly instead of finishing
+// the whole file first.
+func TestCompressContextCancelsMidCopy(t *testing.T) {
+	src := t.TempDir()
+	data := bytes.Repeat([]byte{0xAB}, 8*1024*1024)
+	if err := os.WriteFile(filepath.Join(src, "big.bin"), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var canceled int32
+	bc := &BigCompressor{
+		ioCPBuffer: make([]byte, 4096),
+		OnProgress: func(p Progress) {
+			if p.BytesRead > 1024*1024 && atomic.CompareAndSwapInt32(&canceled, 0, 1) {
+				cancel()
+			}
+		},
+	}
+
+	dst := filepath.Join(t.TempDir(), "out.bin")
+	start := time.Now()
+	err := bc.CompressContext(ctx, src, dst)
+	elapsed := time.Since(start)
+
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("CompressContext error = %v, want context.Canceled", err)
+	}
+	if elapsed > time.Second {
+		t.Fatalf("CompressContext took %v to respect cancellation", elapsed)
+	}
+}
+
+// TestDecompressContextCancelsMidCopy is TestCompressContextCancelsMidCopy's
+// counterpart for the decode side: decompressChunk's own ctxCountingReader
+// must likewise abort a large file's copy promptly once ctx is canceled.
+func TestDecompressContextCancelsMidCopy(t *testing.T) {
+	src := t.TempDir()
+	data := bytes.Repeat([]byte{0xCD}, 8*1024*1024)
+	if err := os.WriteFile(filepath.Join(src, "big.bin"), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	archive := filepath.Join(t.TempDir(), "archive.bin")
+	bc := &BigCompressor{CombineChunk: true}
+	if err := bc.Compress(src, archive); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var canceled int32
+	bc2 := &BigCompressor{
+		MaxDecompressBufferSize: 16 << 20,
+		ioCPBuffer:              make([]byte, 4096),
+		OnProgress: func(p Progress) {
+			if p.BytesRead > 1024*1024 && atomic.CompareAndSwapInt32(&canceled, 0, 1) {
+				cancel()
+			}
+		},
+	}
+
+	dstDir := t.TempDir()
+	start := time.Now()
+	err := bc2.DecompressContext(ctx, archive, dstDir)
+	elapsed := time.Since(start)
+
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("DecompressContext error = %v, want context.Canceled", err)
+	}
+	if elapsed > time.Second {
+		t.Fatalf("DecompressContext took %v to respect cancellation", elapsed)
+	}
+}
+
+// TestCompressContextReportsProgressAndTotalBytes confirms OnProgress
+// actually fires during a run and that Progress.TotalBytes matches the real
+// size of the input, not some placeholder or partial count.
+func TestCompressContextReportsProgressAndTotalBytes(t *testing.T) {
+	src := t.TempDir()
+	var want int64
+	for i, size := range []int{5000, 9000, 70000} {
+		data := bytes.Repeat([]byte{byte(i + 1)}, size)
+		if err := os.WriteFile(filepath.Join(src, string(rune('a'+i))+".bin"), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		want += int64(size)
+	}
+
+	var fired bool
+	var last Progress
+	bc := &BigCompressor{OnProgress: func(p Progress) {
+		fired = true
+		last = p
+	}}
+
+	dst := filepath.Join(t.TempDir(), "out.bin")
+	if err := bc.CompressContext(context.Background(), src, dst); err != nil {
+		t.Fatal(err)
+	}
+
+	if !fired {
+		t.Fatal("OnProgress never fired")
+	}
+	if last.TotalBytes != want {
+		t.Errorf("TotalBytes = %d, want %d", last.TotalBytes, want)
+	}
+	if last.BytesRead != want {
+		t.Errorf("final BytesRead = %d, want %d", last.BytesRead, want)
+	}
+	if last.FilesProcessed == 0 {
+		t.Error("FilesProcessed never incremented")
+	}
+}
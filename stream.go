@@ -0,0 +1,329 @@
+package bigcompressor
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// WritableFile is what DecompressStream needs from an opened destination
+// file: enough to write sequentially and, when content-defined chunking
+// split a source file across several tar entries, seek back to place a
+// later one at its recorded offset.
+type WritableFile interface {
+	io.Writer
+	io.Seeker
+	io.Closer
+}
+
+// WritableFS lets DecompressStream write its output somewhere other than
+// the real filesystem -- an in-memory tree, a staging area, anything that
+// can create directories and open files for writing. Paths passed to
+// MkdirAll/OpenFile are always relative: a tar header's Name after
+// sanitizeEntryName, which cleans it with filepath.Clean.
+type WritableFS interface {
+	MkdirAll(path string, perm os.FileMode) error
+	OpenFile(name string, flag int, perm os.FileMode) (WritableFile, error)
+}
+
+// osWritableFS implements WritableFS by creating files under dir on the real
+// filesystem. It is what Decompress/DecompressContext hand to
+// DecompressStream so the path-based API keeps its existing behavior.
+type osWritableFS struct{ dir string }
+
+func (o osWritableFS) MkdirAll(path string, perm os.FileMode) error {
+	return os.MkdirAll(filepath.Join(o.dir, path), perm)
+}
+
+func (o osWritableFS) OpenFile(name string, flag int, perm os.FileMode) (WritableFile, error) {
+	return os.OpenFile(filepath.Join(o.dir, name), flag, perm)
+}
+
+// CompressStream is CompressContext generalized to an arbitrary fs.FS source
+// and io.Writer destination, decoupled from real filesystem paths: srcFS and
+// root might be os.DirFS and ".", a zip.Reader, or an in-memory FS, and w
+// might be a network connection, os.Stdout, or a bytes.Buffer.
+//
+// It always writes the way CombineChunk does -- chunks concatenated into w
+// separated by chunkseparator -- since a single io.Writer has no notion of
+// "one file per chunk". BuildIndex is still honored: its offsets are plain
+// byte counts through w, not real seeks, so they work on any io.Writer.
+// Parallelism is not used here; CompressContext's parallel path is tied to
+// writing bc.compressFile directly and isn't worth generalizing for this.
+//
+// ContentDefinedChunking requires srcFS's files to implement io.Seeker for
+// any segment after a file's first, since a content-defined boundary in the
+// middle of a file means reopening it and seeking to where that segment
+// starts.
+func (bc *BigCompressor) CompressStream(ctx context.Context, srcFS fs.FS, root string, w io.Writer) error {
+	var dataChunks []*dataChunk
+	if bc.ContentDefinedChunking {
+		var err error
+		dataChunks, err = bc.createContentDefinedChunkInfoFS(srcFS, root)
+		if err != nil {
+			return err
+		}
+	} else {
+		var err error
+		dataChunks, err = createChunkInfoFS(srcFS, root, bc.MaxPrecompressChunkSize)
+		if err != nil {
+			return err
+		}
+	}
+	tracker := newProgressTracker(bc.OnProgress, totalBytesOf(dataChunks))
+
+	if bc.BuildIndex {
+		bc.toc = &tableOfContents{}
+	} else {
+		bc.toc = nil
+	}
+
+	if bc.buffer == nil {
+		bc.buffer = &bytes.Buffer{}
+	}
+	if bc.ioCPBuffer == nil {
+		bc.ioCPBuffer = make([]byte, 32*1024)
+	}
+
+	// compressChunkNoAlloc derives each header's name by stripping this
+	// prefix out of fi.file with strings.Replace. fs.WalkDir never returns
+	// paths prefixed with "./", so root itself isn't a usable prefix to
+	// strip when it's ".": stripping it would instead eat the first dot it
+	// finds in a filename. Passing "" leaves fi.file, which is already
+	// root-relative in that case, untouched.
+	headerPrefix := root
+	if headerPrefix == "." {
+		headerPrefix = ""
+	}
+
+	counter := &countingWriter{w: w}
+	for _, dChunk := range dataChunks {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		tracker.setChunk(dChunk.chunkNumber)
+		chunkOffset := counter.n
+		entries, err := bc.compressChunkNoAlloc(ctx, tracker, headerPrefix, dChunk)
+		if err != nil {
+			return err
+		}
+
+		skip, err := bc.dedupChunk(dChunk.chunkNumber, bc.buffer.Bytes())
+		if err != nil {
+			return err
+		}
+		if skip {
+			bc.buffer.Reset()
+			continue
+		}
+
+		if bc.toc != nil {
+			bc.toc.Chunks = append(bc.toc.Chunks, tocChunk{
+				ChunkNumber: dChunk.chunkNumber,
+				Offset:      chunkOffset,
+				Length:      int64(bc.buffer.Len()),
+			})
+			bc.toc.Entries = append(bc.toc.Entries, entries...)
+		}
+		if _, err := counter.Write(bc.buffer.Bytes()); err != nil {
+			return err
+		}
+		if _, err := counter.Write(chunkseparator.Bytes()); err != nil {
+			return err
+		}
+		bc.buffer.Reset()
+	}
+	if bc.toc != nil {
+		return writeTOCFooter(counter, bc.toc)
+	}
+	return nil
+}
+
+// DecompressStream is DecompressContext generalized to an arbitrary
+// io.Reader source and WritableFS destination, so callers can pipe in
+// stdin, an HTTP response body, or anything else instead of a real file,
+// and write the result into an in-memory tree instead of the real
+// filesystem.
+func (bc *BigCompressor) DecompressStream(ctx context.Context, r io.Reader, dstFS WritableFS) error {
+	if bc.buffer == nil {
+		bc.buffer = &bytes.Buffer{}
+	}
+	bc.buffer.Reset()
+	tracker := newProgressTracker(bc.OnProgress, 0)
+	if bc.ioCPBuffer == nil {
+		bc.ioCPBuffer = make([]byte, 32*1024)
+	}
+	csBytes := chunkseparator.Bytes()
+	scanner := bufio.NewScanner(r)
+	buf := make([]byte, bc.MaxDecompressBufferSize)
+	scanner.Buffer(buf, bufio.MaxScanTokenSize)
+	scanFn := func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		commaidx := bytes.Index(data, csBytes)
+		if commaidx >= 0 {
+			// we need to return the next position. commaidx can legitimately be
+			// 0 -- an empty dataChunk (e.g. a directory entry whose on-disk size
+			// already exceeds MaxPrecompressChunkSize) still gets a separator --
+			// and treating that as "not found" left the scanner accumulating the
+			// rest of the archive into a single bogus token instead of emitting
+			// the empty chunk and advancing past it.
+			buffer := data[:commaidx]
+			return commaidx + len(csBytes), bytes.TrimSpace(buffer), nil
+		}
+		// if we are at the end of the string, just return the entire buffer
+		if atEOF {
+			// but only do that when there is some data. If not, this might mean
+			// that we've reached the end of our input CSV string
+			if len(data) > 0 {
+				return len(data), bytes.TrimSpace(data), nil
+			}
+		}
+
+		// when 0, nil, nil is returned, this is a signal to the interface to read
+		// more data in from the input reader. In this case, this input is our
+		// string reader and this pretty much will never occur.
+		return 0, nil, nil
+	}
+	scanner.Split(scanFn)
+	for scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n := scanner.Bytes()
+		if len(n) > 10 {
+			if _, err := bc.buffer.Write(n); err != nil {
+				return err
+			}
+			if err := bc.decompressChunk(ctx, tracker, dstFS); err != nil {
+				return err
+			}
+			bc.buffer.Reset()
+		}
+	}
+	return nil
+}
+
+// createChunkInfoFS is createChunkInfo generalized to fs.FS, walking srcFS
+// under root instead of a real directory with filepath.Walk.
+func createChunkInfoFS(fsys fs.FS, root string, maxChunkSize int64) ([]*dataChunk, error) {
+	dataChunks := []*dataChunk{{chunkNumber: 0, files: []*fileInfo{}}}
+	currentChunk := 0
+
+	err := fs.WalkDir(fsys, root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		chunk := dataChunks[currentChunk]
+		if chunk.totalSize+info.Size() <= maxChunkSize {
+			chunk.files = append(chunk.files, &fileInfo{file: path, FileInfo: info, fsys: fsys})
+			if !info.IsDir() {
+				chunk.totalSize += info.Size()
+			}
+		} else {
+			currentChunk++
+			dataChunks = append(dataChunks, &dataChunk{chunkNumber: currentChunk, files: []*fileInfo{}})
+			chunk = dataChunks[currentChunk]
+			chunk.files = append(chunk.files, &fileInfo{file: path, FileInfo: info, fsys: fsys})
+			if !info.IsDir() {
+				chunk.totalSize += info.Size()
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dataChunks, nil
+}
+
+// createContentDefinedChunkInfoFS is createContentDefinedChunkInfo
+// generalized to fs.FS; see its doc comment for how boundaries are chosen.
+func (bc *BigCompressor) createContentDefinedChunkInfoFS(fsys fs.FS, root string) ([]*dataChunk, error) {
+	mask := cdcMask(bc.avgChunkSize())
+	minSize := bc.minChunkSize()
+	maxSize := bc.maxChunkSize()
+	maxPrecompress := bc.maxPrecompressChunkSize()
+
+	dataChunks := []*dataChunk{{chunkNumber: 0, files: []*fileInfo{}}}
+	currentChunk := 0
+	cut := func() {
+		currentChunk++
+		dataChunks = append(dataChunks, &dataChunk{chunkNumber: currentChunk, files: []*fileInfo{}})
+	}
+
+	readBuf := make([]byte, 32*1024)
+
+	err := fs.WalkDir(fsys, root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		chunk := dataChunks[currentChunk]
+
+		if info.IsDir() || info.Size() == 0 {
+			chunk.files = append(chunk.files, &fileInfo{file: path, FileInfo: info, fsys: fsys})
+			return nil
+		}
+
+		f, err := fsys.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		var rh rollingHash
+		var segStart, pos int64
+		appendSegment := func(end int64) {
+			segLen := end - segStart
+			chunk.files = append(chunk.files, &fileInfo{
+				file:      path,
+				FileInfo:  info,
+				fsys:      fsys,
+				segOffset: segStart,
+				segLen:    segLen,
+			})
+			chunk.totalSize += segLen
+			segStart = end
+			if chunk.totalSize >= maxPrecompress {
+				cut()
+				chunk = dataChunks[currentChunk]
+			}
+		}
+
+		for {
+			n, rerr := f.Read(readBuf)
+			for i := 0; i < n; i++ {
+				h := rh.roll(readBuf[i])
+				pos++
+				segLen := pos - segStart
+				if segLen >= maxSize || (segLen >= minSize && h&mask == cdcMagic) {
+					appendSegment(pos)
+				}
+			}
+			if rerr == io.EOF {
+				break
+			}
+			if rerr != nil {
+				return rerr
+			}
+		}
+		if pos > segStart {
+			appendSegment(pos)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dataChunks, nil
+}
@@ -0,0 +1,174 @@
+package bigcompressor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// memWritableFile and memWritableFS give CompressStream/DecompressStream a
+// WritableFS destination that isn't the real filesystem, the way a caller
+// streaming into an in-memory tree would use them.
+type memWritableFile struct {
+	buf *bytes.Buffer
+	pos int64
+}
+
+func (f *memWritableFile) Write(p []byte) (int, error) {
+	b := f.buf.Bytes()
+	if f.pos == int64(len(b)) {
+		n, err := f.buf.Write(p)
+		f.pos += int64(n)
+		return n, err
+	}
+	need := f.pos + int64(len(p))
+	if need > int64(len(b)) {
+		grown := make([]byte, need)
+		copy(grown, b)
+		*f.buf = *bytes.NewBuffer(grown)
+	}
+	copy(f.buf.Bytes()[f.pos:], p)
+	f.pos += int64(len(p))
+	return len(p), nil
+}
+
+func (f *memWritableFile) Seek(offset int64, whence int) (int64, error) {
+	f.pos = offset
+	return f.pos, nil
+}
+
+func (f *memWritableFile) Close() error { return nil }
+
+type memWritableFS struct {
+	files map[string]*bytes.Buffer
+}
+
+func newMemWritableFS() *memWritableFS {
+	return &memWritableFS{files: map[string]*bytes.Buffer{}}
+}
+
+func (m *memWritableFS) MkdirAll(path string, perm os.FileMode) error { return nil }
+
+func (m *memWritableFS) OpenFile(name string, flag int, perm os.FileMode) (WritableFile, error) {
+	buf, ok := m.files[name]
+	if !ok {
+		buf = &bytes.Buffer{}
+		m.files[name] = buf
+	}
+	return &memWritableFile{buf: buf}, nil
+}
+
+func writeStreamTestFiles(t *testing.T, dir string, n int) map[string][]byte {
+	t.Helper()
+	want := make(map[string][]byte, n)
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("f%02d.bin", i)
+		data := bytes.Repeat([]byte{byte(i + 1)}, (i+1)*8192)
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		want[name] = data
+	}
+	return want
+}
+
+// TestCompressStreamDecompressStreamRoundTrip is CompressContext/
+// DecompressContext's round trip, but through CompressStream/
+// DecompressStream's fs.FS/io.Writer and io.Reader/WritableFS surfaces
+// instead of real source/destination paths.
+func TestCompressStreamDecompressStreamRoundTrip(t *testing.T) {
+	src := t.TempDir()
+	want := writeStreamTestFiles(t, src, 4)
+
+	var combined bytes.Buffer
+	bc := &BigCompressor{}
+	if err := bc.CompressStream(context.Background(), os.DirFS(src), ".", &combined); err != nil {
+		t.Fatal(err)
+	}
+
+	dstDir := t.TempDir()
+	bc2 := &BigCompressor{}
+	if err := bc2.DecompressStream(context.Background(), bytes.NewReader(combined.Bytes()), osWritableFS{dir: dstDir}); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, data := range want {
+		got, err := os.ReadFile(filepath.Join(dstDir, name))
+		if err != nil {
+			t.Fatalf("reading %q: %v", name, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("content mismatch for %q", name)
+		}
+	}
+}
+
+// TestCompressStreamDecompressStreamIntoMemoryFS is the same round trip but
+// into an in-memory WritableFS, confirming DecompressStream doesn't assume
+// its destination is the real filesystem.
+func TestCompressStreamDecompressStreamIntoMemoryFS(t *testing.T) {
+	src := t.TempDir()
+	want := writeStreamTestFiles(t, src, 3)
+
+	var combined bytes.Buffer
+	bc := &BigCompressor{}
+	if err := bc.CompressStream(context.Background(), os.DirFS(src), ".", &combined); err != nil {
+		t.Fatal(err)
+	}
+
+	dstFS := newMemWritableFS()
+	bc2 := &BigCompressor{}
+	if err := bc2.DecompressStream(context.Background(), bytes.NewReader(combined.Bytes()), dstFS); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, data := range want {
+		buf, ok := dstFS.files[name]
+		if !ok {
+			t.Fatalf("memWritableFS missing %q", name)
+		}
+		if !bytes.Equal(buf.Bytes(), data) {
+			t.Errorf("content mismatch for %q", name)
+		}
+	}
+}
+
+// TestCompressStreamContentDefinedChunkingSplitsAndReassembles exercises the
+// case CompressStream's doc comment calls out: a
+// content-defined boundary falling mid-file splits it into more than one
+// segment, and reopening the file for any segment after the first requires
+// it to support io.Seeker -- true of the *os.File os.DirFS hands back, which
+// this test relies on.
+func TestCompressStreamContentDefinedChunkingSplitsAndReassembles(t *testing.T) {
+	src := t.TempDir()
+	data := make([]byte, 300*1024)
+	for i := range data {
+		data[i] = byte(i*13 + 7)
+	}
+	if err := os.WriteFile(filepath.Join(src, "big.bin"), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var combined bytes.Buffer
+	bc := &BigCompressor{ContentDefinedChunking: true, MinChunkSize: 4096, AvgChunkSize: 16384, MaxChunkSize: 32768}
+	if err := bc.CompressStream(context.Background(), os.DirFS(src), ".", &combined); err != nil {
+		t.Fatal(err)
+	}
+
+	dstDir := t.TempDir()
+	bc2 := &BigCompressor{}
+	if err := bc2.DecompressStream(context.Background(), bytes.NewReader(combined.Bytes()), osWritableFS{dir: dstDir}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dstDir, "big.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("content-defined split/reassembled file does not match source")
+	}
+}